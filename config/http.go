@@ -0,0 +1,77 @@
+// Copyright 2018-2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "crypto/tls"
+
+// Http denotes configuration for the standalone HTTP server that Olric
+// exposes for administrative purposes, such as health checks and stats.
+type Http struct {
+	// Enabled controls whether the HTTP server is started at all.
+	Enabled bool
+
+	// Addr is the TCP address the HTTP server listens on, e.g. "0.0.0.0:5678".
+	Addr string
+
+	// TLSCertFile is the path to a PEM encoded certificate used to serve
+	// the HTTP API over TLS. Both TLSCertFile and TLSKeyFile must be set
+	// for them to enable TLS; setting TLSConfig alone enables TLS too.
+	TLSCertFile string
+
+	// TLSKeyFile is the path to the PEM encoded private key matching
+	// TLSCertFile.
+	TLSKeyFile string
+
+	// TLSConfig, when set, is used as the base *tls.Config for the HTTP
+	// server instead of constructing one from TLSCertFile/TLSKeyFile
+	// alone. This allows callers to customize things like client
+	// authentication or cipher suites.
+	TLSConfig *tls.Config
+
+	// EnableHTTP2 turns on HTTP/2 support over the TLS listener. It has
+	// no effect unless TLS is also configured.
+	EnableHTTP2 bool
+
+	// ACME, when set, makes the HTTP server obtain and renew its TLS
+	// certificate automatically from an ACME provider such as Let's
+	// Encrypt instead of reading TLSCertFile/TLSKeyFile from disk.
+	ACME *ACME
+}
+
+// ACME holds the configuration needed to run an autocert.Manager for the
+// HTTP server.
+type ACME struct {
+	// Domains is the list of hostnames the certificate should be valid
+	// for. Requests for any other hostname are rejected.
+	Domains []string
+
+	// Email is passed to the ACME provider and used for expiry and
+	// other account notifications.
+	Email string
+
+	// CacheDir is the directory certificates and account keys are
+	// persisted to across restarts.
+	CacheDir string
+
+	// Staging routes requests to the ACME provider's staging directory
+	// instead of its production one. Useful for testing without
+	// hitting rate limits.
+	Staging bool
+
+	// HTTPChallengeAddr is the address a plain HTTP listener is bound
+	// to in order to answer HTTP-01 challenges and to redirect regular
+	// traffic to HTTPS.
+	HTTPChallengeAddr string
+}
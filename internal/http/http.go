@@ -0,0 +1,312 @@
+// Copyright 2018-2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package http implements the standalone HTTP server Olric exposes for
+// administrative and operational purposes.
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/buraksezer/olric/config"
+	"github.com/buraksezer/olric/pkg/flog"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+)
+
+// listenFD is the file descriptor a socket-activated process finds its
+// inherited listener bound to, following the systemd/fork-exec
+// convention of handing down sockets starting at fd 3. readyFD is the
+// control pipe Reload uses to learn when the new process is ready to
+// take over.
+const (
+	listenFD = 3
+	readyFD  = 4
+)
+
+// Server is a lightweight HTTP server used to expose Olric's management
+// API.
+type Server struct {
+	config     *config.Http
+	log        *flog.Logger
+	handler    http.Handler
+	httpServer *http.Server
+	listener   net.Listener
+
+	// acmeServer answers HTTP-01 challenges and redirects plain HTTP
+	// traffic to HTTPS. It's only set when config.ACME is enabled.
+	acmeServer   *http.Server
+	acmeListener net.Listener
+
+	// ListenerFile, when set, is used to build the server's net.Listener
+	// instead of calling net.Listen. This is how a freshly exec'd
+	// process picks up the listening socket handed down by Reload.
+	ListenerFile *os.File
+
+	// ReloadBinaryPath overrides the executable Reload re-execs to
+	// perform a hot-restart. Defaults to /proc/self/exe.
+	ReloadBinaryPath string
+
+	// ReloadArgs overrides the arguments Reload passes to the
+	// replacement process. Defaults to os.Args[1:], i.e. re-exec with
+	// the same arguments the current process was started with.
+	ReloadArgs []string
+
+	// StartedCtx is canceled once the server has started accepting
+	// connections. It's primarily useful in tests.
+	StartedCtx context.Context
+	started    context.CancelFunc
+}
+
+// New creates a Server that will serve handler once Start is called.
+func New(c *config.Http, log *flog.Logger, handler http.Handler) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		config:     c,
+		log:        log,
+		handler:    handler,
+		StartedCtx: ctx,
+		started:    cancel,
+	}
+}
+
+func (s *Server) tlsConfigured() bool {
+	return s.config.TLSConfig != nil || (s.config.TLSCertFile != "" && s.config.TLSKeyFile != "")
+}
+
+// listen builds the server's net.Listener. When LISTEN_FDS is set in the
+// environment or ListenerFile is supplied, the socket is inherited from
+// the parent process instead of being freshly bound, which is what makes
+// Reload a zero-downtime handover.
+func (s *Server) listen() (net.Listener, error) {
+	file := s.ListenerFile
+	if file == nil && os.Getenv("LISTEN_FDS") != "" {
+		file = os.NewFile(uintptr(listenFD), "olric-http-listener")
+	}
+	if file != nil {
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit listener: %w", err)
+		}
+		// net.FileListener dups file; our copy is no longer needed.
+		file.Close()
+		return listener, nil
+	}
+	return net.Listen("tcp", s.config.Addr)
+}
+
+// signalReady notifies a parent process that handed this process its
+// listener (via Reload) that it's now safe to shut the parent down.
+func (s *Server) signalReady() {
+	if s.ListenerFile == nil && os.Getenv("LISTEN_FDS") == "" {
+		return
+	}
+	f := os.NewFile(uintptr(readyFD), "olric-http-ready")
+	defer f.Close()
+	fmt.Fprintln(f, "ready")
+}
+
+// Start starts serving the HTTP server and blocks until it's shut down via
+// Shutdown. It returns nil on a graceful shutdown.
+func (s *Server) Start() error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.httpServer = &http.Server{
+		Handler: s.handler,
+	}
+
+	if s.config.ACME != nil {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.config.ACME.Domains...),
+			Cache:      autocert.DirCache(s.config.ACME.CacheDir),
+			Email:      s.config.ACME.Email,
+		}
+		if s.config.ACME.Staging {
+			manager.Client = &acme.Client{DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory"}
+		}
+
+		acmeListener, err := net.Listen("tcp", s.config.ACME.HTTPChallengeAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.config.ACME.HTTPChallengeAddr, err)
+		}
+		s.acmeListener = acmeListener
+		s.acmeServer = &http.Server{Handler: manager.HTTPHandler(nil)}
+
+		go func() {
+			s.log.V(2).Printf("[INFO] ACME HTTP-01 challenge listener is running on %s", s.config.ACME.HTTPChallengeAddr)
+			if aerr := s.acmeServer.Serve(acmeListener); aerr != nil && aerr != http.ErrServerClosed {
+				s.log.V(1).Printf("[ERROR] ACME challenge listener failed: %v", aerr)
+			}
+		}()
+
+		s.httpServer.TLSConfig = manager.TLSConfig()
+		if s.config.EnableHTTP2 {
+			if err := http2.ConfigureServer(s.httpServer, &http2.Server{}); err != nil {
+				_ = s.acmeServer.Close()
+				return fmt.Errorf("failed to configure HTTP/2: %w", err)
+			}
+		} else {
+			// A non-nil, empty TLSNextProto keeps net/http from enabling
+			// HTTP/2 on its own; "h2" is also dropped from the ALPN list
+			// autocert advertises.
+			s.httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+			s.httpServer.TLSConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		}
+
+		s.log.V(2).Printf("[INFO] Olric HTTP server is listening on %s with ACME-managed TLS", s.config.Addr)
+		s.started()
+		s.signalReady()
+		err = s.httpServer.ServeTLS(listener, "", "")
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+
+	if s.tlsConfigured() {
+		tlsConfig := s.config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+
+		s.httpServer.TLSConfig = tlsConfig
+		if s.config.EnableHTTP2 {
+			if err := http2.ConfigureServer(s.httpServer, &http2.Server{}); err != nil {
+				return fmt.Errorf("failed to configure HTTP/2: %w", err)
+			}
+		} else {
+			// A non-nil, empty TLSNextProto keeps net/http from enabling
+			// HTTP/2 on its own in ServeTLS.
+			s.httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+
+		s.log.V(2).Printf("[INFO] Olric HTTP server is listening on %s with TLS", s.config.Addr)
+		s.started()
+		s.signalReady()
+		err = s.httpServer.ServeTLS(listener, s.config.TLSCertFile, s.config.TLSKeyFile)
+	} else {
+		s.log.V(2).Printf("[INFO] Olric HTTP server is listening on %s", s.config.Addr)
+		s.started()
+		s.signalReady()
+		err = s.httpServer.Serve(listener)
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the HTTP server without interrupting any
+// active connections.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+
+	err := s.httpServer.Shutdown(ctx)
+	if s.acmeServer != nil {
+		if aerr := s.acmeServer.Shutdown(ctx); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+	return err
+}
+
+// Reload performs a zero-downtime hot-restart: it hands the listening
+// socket down to a freshly exec'd copy of the running binary, waits for
+// that child to signal readiness, and only then shuts down the current
+// process's HTTP server so in-flight requests can drain.
+func (s *Server) Reload(ctx context.Context) error {
+	tcpListener, ok := s.listener.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("reload requires a TCP listener, got %T", s.listener)
+	}
+
+	listenerFile, err := tcpListener.File()
+	if err != nil {
+		return fmt.Errorf("failed to dup listener fd: %w", err)
+	}
+	defer listenerFile.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer readyReader.Close()
+	defer readyWriter.Close()
+
+	binary := s.ReloadBinaryPath
+	if binary == "" {
+		binary = "/proc/self/exe"
+	}
+	args := s.ReloadArgs
+	if args == nil {
+		args = os.Args[1:]
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{listenerFile, readyWriter}
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1")
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to exec replacement process: %w", err)
+	}
+	// The child owns its end of the pipe now; closing our copy lets us
+	// observe EOF if the child dies before signaling readiness.
+	readyWriter.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(readyReader)
+		if scanner.Scan() && scanner.Text() == "ready" {
+			ready <- nil
+			return
+		}
+		if err := scanner.Err(); err != nil {
+			ready <- err
+			return
+		}
+		ready <- fmt.Errorf("replacement process exited before signaling readiness")
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		return ctx.Err()
+	}
+
+	return s.Shutdown(ctx)
+}
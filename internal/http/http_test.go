@@ -16,11 +16,20 @@ package http
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
 	"log"
+	"math/big"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -33,6 +42,35 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// generateSelfSignedCert creates an in-memory self-signed certificate for
+// "127.0.0.1", suitable for exercising the TLS/HTTP2 code paths without
+// touching the filesystem.
+func generateSelfSignedCert() (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
 func getRandomAddr() (string, int, error) {
 	addr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -122,10 +160,7 @@ func TestHTTP_MiddlewareChain(t *testing.T) {
 	logger.ShowLineNumber(1)
 
 	r := httprouter.New()
-	r.HandlerFunc("GET", "/api/v1/system/aliveness", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNoContent)
-	})
-	r.HandlerFunc("GET", "/api/v1/foobar", func(w http.ResponseWriter, r *http.Request) {})
+	router := NewRouter(r)
 
 	var num int32
 	increase := func() error {
@@ -136,7 +171,12 @@ func TestHTTP_MiddlewareChain(t *testing.T) {
 		atomic.AddInt32(&num, -1)
 		return nil
 	}
-	router := NewRouter(r, is_operable.New(increase), is_operable.New(decrease))
+	router.Use(is_operable.New(increase), is_operable.New(decrease))
+
+	router.Handle("GET", "/api/v1/system/aliveness", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	router.Handle("GET", "/api/v1/foobar", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 
 	// Create a new HTTP server
 	srv := New(c, logger, router)
@@ -182,3 +222,436 @@ func TestHTTP_MiddlewareChain(t *testing.T) {
 		t.Fatalf("Expected nil. Got: %v", err)
 	}
 }
+
+func TestHTTP_Start_TLS(t *testing.T) {
+	cert, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	bindAddr, bindPort, err := getRandomAddr()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+	addr := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	c := &config.Http{
+		Enabled:     true,
+		Addr:        addr,
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		EnableHTTP2: true,
+	}
+
+	logger := flog.New(log.New(os.Stderr, "", log.LstdFlags))
+	logger.SetLevel(6)
+	logger.ShowLineNumber(1)
+	router := httprouter.New()
+	router.HandlerFunc("GET", "/api/v1/foobar", func(w http.ResponseWriter, r *http.Request) {})
+
+	srv := New(c, logger, router)
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		return srv.Start()
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	select {
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			t.Errorf("Expected nil. Got: %v", err)
+		}
+	case <-srv.StartedCtx.Done():
+		resp, herr := client.Get("https://" + addr + "/api/v1/foobar")
+		if herr != nil {
+			t.Fatalf("Expected nil. Got: %v", herr)
+		}
+		defer resp.Body.Close()
+		if resp.Proto != "HTTP/2.0" {
+			t.Fatalf("Expected HTTP/2.0. Got: %s", resp.Proto)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Failed to start a new HTTP server")
+	}
+
+	err = srv.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	err = g.Wait()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+}
+
+func TestHTTP_MiddlewareChain_TLS(t *testing.T) {
+	cert, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	bindAddr, bindPort, err := getRandomAddr()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+	addr := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	c := &config.Http{
+		Enabled:     true,
+		Addr:        addr,
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		EnableHTTP2: true,
+	}
+
+	logger := flog.New(log.New(os.Stderr, "", log.LstdFlags))
+	logger.SetLevel(6)
+	logger.ShowLineNumber(1)
+
+	r := httprouter.New()
+	router := NewRouter(r)
+
+	var num int32
+	increase := func() error {
+		atomic.AddInt32(&num, 1)
+		return nil
+	}
+	decrease := func() error {
+		atomic.AddInt32(&num, -1)
+		return nil
+	}
+	router.Use(is_operable.New(increase), is_operable.New(decrease))
+	router.Handle("GET", "/api/v1/foobar", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	srv := New(c, logger, router)
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		return srv.Start()
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	select {
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			t.Errorf("Expected nil. Got: %v", err)
+		}
+	case <-srv.StartedCtx.Done():
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, herr := client.Get("https://" + addr + "/api/v1/foobar")
+				if herr != nil {
+					t.Fatalf("Expected nil. Got: %v", herr)
+				}
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+		if atomic.LoadInt32(&num) != 0 {
+			t.Fatalf("Expected value is 0. Got: %d", atomic.LoadInt32(&num))
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Failed to start a new HTTP server")
+	}
+
+	err = srv.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	err = g.Wait()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+}
+
+func TestHTTP_Reload(t *testing.T) {
+	if os.Getenv("OLRIC_HTTP_RELOAD_HELPER") == "1" {
+		runReloadHelper()
+		return
+	}
+
+	bindAddr, bindPort, err := getRandomAddr()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+	addr := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	c := &config.Http{
+		Enabled: true,
+		Addr:    addr,
+	}
+
+	logger := flog.New(log.New(os.Stderr, "", log.LstdFlags))
+	logger.SetLevel(6)
+	logger.ShowLineNumber(1)
+
+	router := httprouter.New()
+	router.HandlerFunc("GET", "/api/v1/foobar", func(w http.ResponseWriter, r *http.Request) {})
+
+	srv := New(c, logger, router)
+	srv.ReloadBinaryPath = os.Args[0]
+	// Scope the re-exec to just this test instead of inheriting the
+	// parent's -test.run, otherwise the child would run the whole
+	// package's test suite before ever reaching the readiness handshake.
+	srv.ReloadArgs = []string{"-test.run=^TestHTTP_Reload$", "-test.v=false"}
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		return srv.Start()
+	})
+
+	select {
+	case <-srv.StartedCtx.Done():
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("Expected nil. Got: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Failed to start a new HTTP server")
+	}
+
+	stop := make(chan struct{})
+	var failed int32
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			resp, herr := http.DefaultClient.Get("http://" + addr + "/api/v1/foobar")
+			if herr != nil {
+				atomic.AddInt32(&failed, 1)
+				continue
+			}
+			resp.Body.Close()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	if err := os.Setenv("OLRIC_HTTP_RELOAD_HELPER", "1"); err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+	defer os.Unsetenv("OLRIC_HTTP_RELOAD_HELPER")
+
+	reloadCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Reload(reloadCtx); err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if atomic.LoadInt32(&failed) != 0 {
+		t.Fatalf("Expected no dropped connections across reload. Got: %d failures", failed)
+	}
+}
+
+// runReloadHelper is re-exec'd by Reload as the replacement process in
+// TestHTTP_Reload. It picks up the inherited listener via LISTEN_FDS and
+// shuts itself down shortly after starting, since nothing else will stop
+// a detached test helper process.
+func runReloadHelper() {
+	logger := flog.New(log.New(os.Stderr, "", log.LstdFlags))
+
+	router := httprouter.New()
+	router.HandlerFunc("GET", "/api/v1/foobar", func(w http.ResponseWriter, r *http.Request) {})
+
+	srv := New(&config.Http{Enabled: true}, logger, router)
+	time.AfterFunc(3*time.Second, func() {
+		_ = srv.Shutdown(context.Background())
+	})
+	_ = srv.Start()
+}
+
+func TestHTTP_Metrics(t *testing.T) {
+	bindAddr, bindPort, err := getRandomAddr()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+	addr := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	c := &config.Http{
+		Enabled: true,
+		Addr:    addr,
+	}
+
+	logger := flog.New(log.New(os.Stderr, "", log.LstdFlags))
+	logger.SetLevel(6)
+	logger.ShowLineNumber(1)
+
+	r := httprouter.New()
+	router := NewRouter(r)
+	router.Handle("GET", "/api/v1/foobar", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	srv := New(c, logger, router)
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		return srv.Start()
+	})
+
+	select {
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			t.Errorf("Expected nil. Got: %v", err)
+		}
+	case <-srv.StartedCtx.Done():
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, herr := http.DefaultClient.Get("http://" + addr + "/api/v1/foobar")
+				if herr != nil {
+					t.Fatalf("Expected nil. Got: %v", herr)
+				}
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+
+		resp, herr := http.DefaultClient.Get("http://" + addr + "/metrics")
+		if herr != nil {
+			t.Fatalf("Expected nil. Got: %v", herr)
+		}
+		defer resp.Body.Close()
+		body, herr := io.ReadAll(resp.Body)
+		if herr != nil {
+			t.Fatalf("Expected nil. Got: %v", herr)
+		}
+		out := string(body)
+
+		wantCounter := `olric_http_requests_total{route="/api/v1/foobar"} 20`
+		if !strings.Contains(out, wantCounter) {
+			t.Fatalf("Expected %q in /metrics output. Got: %s", wantCounter, out)
+		}
+
+		wantInFlight := `olric_http_requests_in_flight{route="/api/v1/foobar"} 0`
+		if !strings.Contains(out, wantInFlight) {
+			t.Fatalf("Expected %q in /metrics output. Got: %s", wantInFlight, out)
+		}
+
+		wantCount := `olric_http_request_duration_seconds_count{route="/api/v1/foobar"} 20`
+		if !strings.Contains(out, wantCount) {
+			t.Fatalf("Expected %q in /metrics output. Got: %s", wantCount, out)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Failed to start a new HTTP server")
+	}
+
+	err = srv.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	err = g.Wait()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+}
+
+func TestHTTP_Router_Group(t *testing.T) {
+	bindAddr, bindPort, err := getRandomAddr()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+	addr := net.JoinHostPort(bindAddr, strconv.Itoa(bindPort))
+
+	c := &config.Http{
+		Enabled: true,
+		Addr:    addr,
+	}
+
+	logger := flog.New(log.New(os.Stderr, "", log.LstdFlags))
+	logger.SetLevel(6)
+	logger.ShowLineNumber(1)
+
+	r := httprouter.New()
+	router := NewRouter(r)
+
+	var public, admin int32
+	countPublic := func() error {
+		atomic.AddInt32(&public, 1)
+		return nil
+	}
+	countAdmin := func() error {
+		atomic.AddInt32(&admin, 1)
+		return nil
+	}
+
+	router.Handle("GET", "/api/v1/public", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		is_operable.New(countPublic))
+
+	adminGroup := router.Group("/api/v1/admin", is_operable.New(countAdmin))
+	adminGroup.Handle("GET", "/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	srv := New(c, logger, router)
+
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go(func() error {
+		return srv.Start()
+	})
+
+	select {
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			t.Errorf("Expected nil. Got: %v", err)
+		}
+	case <-srv.StartedCtx.Done():
+		resp, herr := http.DefaultClient.Get("http://" + addr + "/api/v1/public")
+		if herr != nil {
+			t.Fatalf("Expected nil. Got: %v", herr)
+		}
+		resp.Body.Close()
+
+		resp, herr = http.DefaultClient.Get("http://" + addr + "/api/v1/admin/stats")
+		if herr != nil {
+			t.Fatalf("Expected nil. Got: %v", herr)
+		}
+		resp.Body.Close()
+
+		if atomic.LoadInt32(&public) != 1 {
+			t.Fatalf("Expected public middleware to run once. Got: %d", atomic.LoadInt32(&public))
+		}
+		if atomic.LoadInt32(&admin) != 1 {
+			t.Fatalf("Expected admin middleware to run once. Got: %d", atomic.LoadInt32(&admin))
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Failed to start a new HTTP server")
+	}
+
+	err = srv.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+
+	err = g.Wait()
+	if err != nil {
+		t.Fatalf("Expected nil. Got: %v", err)
+	}
+}
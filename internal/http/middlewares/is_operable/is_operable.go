@@ -0,0 +1,50 @@
+// Copyright 2018-2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package is_operable implements a middleware that calls a caller supplied
+// function for every request, and is used to gate traffic while Olric is
+// bootstrapping or shutting down.
+package is_operable
+
+import "net/http"
+
+// Func is called once for every request that enters the middleware,
+// before the wrapped handler runs. Implementations typically bump an
+// atomic counter.
+type Func func() error
+
+// New returns a constructor that wraps an http.Handler, invoking fn before
+// delegating to the wrapped handler. If fn returns an error, the request
+// is rejected with a 503.
+func New(fn Func) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &isOperable{
+			next: next,
+			fn:   fn,
+		}
+	}
+}
+
+type isOperable struct {
+	next http.Handler
+	fn   Func
+}
+
+func (i *isOperable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := i.fn(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	i.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,176 @@
+// Copyright 2018-2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics implements a middleware that records per-route request
+// counts, in-flight gauges, and latency histograms, and exposes them in
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// bucketBoundsMs are the upper bounds, in milliseconds, of the fixed
+// exponential latency buckets: 1ms up to ~30s. The final, implicit bucket
+// is +Inf.
+var bucketBoundsMs = []float64{
+	1, 2, 4, 8, 16, 32, 64, 128, 256, 512,
+	1024, 2048, 4096, 8192, 16384, 30000,
+}
+
+// routeMetrics holds the counters for a single route. All fields are
+// updated exclusively via sync/atomic so the request path never takes a
+// lock.
+type routeMetrics struct {
+	requests int64
+	inFlight int64
+	sumNanos int64
+	buckets  []int64 // len(bucketBoundsMs)+1, the last slot is +Inf
+}
+
+func newRouteMetrics() *routeMetrics {
+	return &routeMetrics{buckets: make([]int64, len(bucketBoundsMs)+1)}
+}
+
+func (rm *routeMetrics) observe(d time.Duration) {
+	atomic.AddInt64(&rm.requests, 1)
+	atomic.AddInt64(&rm.sumNanos, int64(d))
+
+	ms := float64(d) / float64(time.Millisecond)
+	idx := len(bucketBoundsMs)
+	for i, bound := range bucketBoundsMs {
+		if ms <= bound {
+			idx = i
+			break
+		}
+	}
+	atomic.AddInt64(&rm.buckets[idx], 1)
+}
+
+// Metrics collects request metrics keyed by route and renders them for a
+// Prometheus scrape.
+type Metrics struct {
+	routes sync.Map // string -> *routeMetrics
+}
+
+// New creates an empty Metrics collector.
+func New() *Metrics {
+	return &Metrics{}
+}
+
+func (m *Metrics) routeFor(name string) *routeMetrics {
+	if v, ok := m.routes.Load(name); ok {
+		return v.(*routeMetrics)
+	}
+	actual, _ := m.routes.LoadOrStore(name, newRouteMetrics())
+	return actual.(*routeMetrics)
+}
+
+// routeLabel reconstructs the httprouter pattern a request matched, e.g.
+// "/api/v1/dmaps/:name", instead of the raw URL, so the route label's
+// cardinality stays bounded. It works by substituting each captured path
+// parameter's value back with its name in the request path, using the
+// httprouter.Params stashed in the request context by the router. If no
+// params were captured (including the case of a request that never
+// reached a matched handler), it falls back to the raw path.
+func routeLabel(r *http.Request) string {
+	ps := httprouter.ParamsFromContext(r.Context())
+	if len(ps) == 0 {
+		return r.URL.Path
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		for _, p := range ps {
+			if segment == p.Value {
+				segments[i] = ":" + p.Key
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Middleware wraps next, recording a request count, an in-flight gauge,
+// and a latency observation for every request it sees.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rm := m.routeFor(routeLabel(r))
+
+		atomic.AddInt64(&rm.inFlight, 1)
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		rm.observe(time.Since(start))
+		atomic.AddInt64(&rm.inFlight, -1)
+	})
+}
+
+// Handler renders the collected metrics in Prometheus text exposition
+// format. It's meant to be mounted at /metrics.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		var b strings.Builder
+		b.WriteString("# HELP olric_http_requests_total Total number of HTTP requests.\n")
+		b.WriteString("# TYPE olric_http_requests_total counter\n")
+		m.routes.Range(func(key, value interface{}) bool {
+			rm := value.(*routeMetrics)
+			fmt.Fprintf(&b, "olric_http_requests_total{route=%q} %d\n", key, atomic.LoadInt64(&rm.requests))
+			return true
+		})
+
+		b.WriteString("# HELP olric_http_requests_in_flight Number of in-flight HTTP requests.\n")
+		b.WriteString("# TYPE olric_http_requests_in_flight gauge\n")
+		m.routes.Range(func(key, value interface{}) bool {
+			rm := value.(*routeMetrics)
+			fmt.Fprintf(&b, "olric_http_requests_in_flight{route=%q} %d\n", key, atomic.LoadInt64(&rm.inFlight))
+			return true
+		})
+
+		b.WriteString("# HELP olric_http_request_duration_seconds Latency distribution of HTTP requests.\n")
+		b.WriteString("# TYPE olric_http_request_duration_seconds histogram\n")
+		m.routes.Range(func(key, value interface{}) bool {
+			route := key.(string)
+			rm := value.(*routeMetrics)
+
+			var cumulative int64
+			for i, bound := range bucketBoundsMs {
+				cumulative += atomic.LoadInt64(&rm.buckets[i])
+				fmt.Fprintf(&b, "olric_http_request_duration_seconds_bucket{route=%q,le=%q} %d\n",
+					route, formatSeconds(bound), cumulative)
+			}
+			cumulative += atomic.LoadInt64(&rm.buckets[len(bucketBoundsMs)])
+			fmt.Fprintf(&b, "olric_http_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, cumulative)
+			fmt.Fprintf(&b, "olric_http_request_duration_seconds_sum{route=%q} %f\n",
+				route, float64(atomic.LoadInt64(&rm.sumNanos))/float64(time.Second))
+			fmt.Fprintf(&b, "olric_http_request_duration_seconds_count{route=%q} %d\n",
+				route, atomic.LoadInt64(&rm.requests))
+			return true
+		})
+
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
+
+func formatSeconds(ms float64) string {
+	return fmt.Sprintf("%g", ms/1000)
+}
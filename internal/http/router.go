@@ -0,0 +1,91 @@
+// Copyright 2018-2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/buraksezer/olric/internal/http/middlewares/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Middleware wraps an http.Handler with additional behavior, such as
+// auth, rate-limiting, tracing, or CORS.
+type Middleware func(http.Handler) http.Handler
+
+// Router builds chains of Middleware around an underlying
+// *httprouter.Router. Every route it registers is instrumented
+// uniformly with Prometheus metrics, scraped by default at /metrics.
+type Router struct {
+	r      *httprouter.Router
+	prefix string
+	mw     []Middleware
+	m      *metrics.Metrics
+}
+
+// NewRouter wraps r and returns the root Router.
+func NewRouter(r *httprouter.Router) *Router {
+	m := metrics.New()
+	r.Handler(http.MethodGet, "/metrics", m.Handler())
+
+	return &Router{
+		r:  r,
+		m:  m,
+		mw: []Middleware{m.Middleware},
+	}
+}
+
+// Use appends mw to the chain applied to every route subsequently
+// registered through this Router, directly or via Group/Handle. It has
+// no effect on routes already registered.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.mw = append(rt.mw, mw...)
+}
+
+// Group returns a new Router scoped under prefix, which inherits this
+// Router's current middleware chain plus mw. Routes registered on the
+// returned Router don't affect this one.
+func (rt *Router) Group(prefix string, mw ...Middleware) *Router {
+	return &Router{
+		r:      rt.r,
+		prefix: rt.prefix + prefix,
+		mw:     chain(rt.mw, mw),
+		m:      rt.m,
+	}
+}
+
+// Handle registers h at method and path, relative to this Router's
+// prefix, wrapped by this Router's middleware chain followed by mw. mw
+// runs closest to h, after every middleware added via Use or inherited
+// from a parent Group.
+func (rt *Router) Handle(method, path string, h http.Handler, mw ...Middleware) {
+	full := chain(rt.mw, mw)
+	for i := len(full) - 1; i >= 0; i-- {
+		h = full[i](h)
+	}
+	rt.r.Handler(method, rt.prefix+path, h)
+}
+
+// ServeHTTP makes Router usable as the Handler passed to New.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.r.ServeHTTP(w, r)
+}
+
+func chain(base, extra []Middleware) []Middleware {
+	out := make([]Middleware, 0, len(base)+len(extra))
+	out = append(out, base...)
+	out = append(out, extra...)
+	return out
+}
@@ -0,0 +1,84 @@
+// Copyright 2018-2020 Burak Sezer
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package flog implements a thin, leveled wrapper around the standard
+// library logger.
+package flog
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Logger wraps *log.Logger with a verbosity level that can be adjusted at
+// runtime.
+type Logger struct {
+	logger *log.Logger
+	level  int32
+}
+
+// New creates a new Logger on top of l. The default verbosity level is 3.
+func New(l *log.Logger) *Logger {
+	return &Logger{
+		logger: l,
+		level:  3,
+	}
+}
+
+// SetLevel sets the verbosity level. Messages logged through V(level) are
+// only emitted when level is less than or equal to the configured level.
+func (l *Logger) SetLevel(level int) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// ShowLineNumber toggles the standard log.Lshortfile flag depending on n.
+func (l *Logger) ShowLineNumber(n int) {
+	if n != 0 {
+		l.logger.SetFlags(l.logger.Flags() | log.Lshortfile)
+		return
+	}
+	l.logger.SetFlags(l.logger.Flags() &^ log.Lshortfile)
+}
+
+// Verbose is returned by V and gates logging on whether its level is
+// enabled.
+type Verbose struct {
+	enabled bool
+	logger  *log.Logger
+}
+
+// V returns a Verbose that is enabled when level is less than or equal to
+// the logger's configured level.
+func (l *Logger) V(level int) Verbose {
+	return Verbose{
+		enabled: int32(level) <= atomic.LoadInt32(&l.level),
+		logger:  l.logger,
+	}
+}
+
+// Printf logs in the manner of log.Printf if v is enabled.
+func (v Verbose) Printf(format string, args ...interface{}) {
+	if !v.enabled {
+		return
+	}
+	v.logger.Printf(format, args...)
+}
+
+// Println logs in the manner of log.Println if v is enabled.
+func (v Verbose) Println(args ...interface{}) {
+	if !v.enabled {
+		return
+	}
+	v.logger.Println(args...)
+}